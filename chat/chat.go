@@ -0,0 +1,209 @@
+// Package chat implements Minecraft-style rich chat components: a tree of styled text nodes
+// with click/hover actions, plus a parser for legacy "§"-coded strings.
+package chat
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OptBool is a tri-valued boolean (unset / true / false). Its zero value is unset, and
+// Component's JSON marshaling omits an unset OptBool entirely so clients only ever see
+// attributes a component explicitly styled.
+type OptBool struct {
+	set   bool
+	value bool
+}
+
+// BoolValue returns an OptBool explicitly set to v.
+func BoolValue(v bool) OptBool {
+	return OptBool{set: true, value: v}
+}
+
+func (o OptBool) IsSet() bool { return o.set }
+func (o OptBool) Value() bool { return o.value }
+
+func (o OptBool) ptr() *bool {
+	if !o.set {
+		return nil
+	}
+	v := o.value
+	return &v
+}
+
+func optBoolFromPtr(p *bool) OptBool {
+	if p == nil {
+		return OptBool{}
+	}
+	return OptBool{set: true, value: *p}
+}
+
+// ClickAction is the allow-listed set of actions a ClickEvent may trigger.
+type ClickAction string
+
+const (
+	ClickOpenURL         ClickAction = "open_url"
+	ClickRunCommand      ClickAction = "run_command"
+	ClickSuggestCommand  ClickAction = "suggest_command"
+	ClickCopyToClipboard ClickAction = "copy_to_clipboard"
+)
+
+var allowedClickActions = map[ClickAction]bool{
+	ClickOpenURL:         true,
+	ClickRunCommand:      true,
+	ClickSuggestCommand:  true,
+	ClickCopyToClipboard: true,
+}
+
+// HoverAction is the allow-listed set of actions a HoverEvent may trigger.
+type HoverAction string
+
+const (
+	HoverShowText HoverAction = "show_text"
+	HoverShowItem HoverAction = "show_item"
+)
+
+// ClickEvent fires when the client clicks a Component.
+type ClickEvent struct {
+	Action ClickAction `json:"action"`
+	Value  string      `json:"value"`
+}
+
+// HoverEvent fires when the client hovers over a Component.
+type HoverEvent struct {
+	Action   HoverAction `json:"action"`
+	Contents *Component  `json:"contents,omitempty"`
+}
+
+// Component is a single styled chat node. Extra holds child components that inherit this
+// component's style unless they override it, mirroring Minecraft's chat component model.
+type Component struct {
+	Text       string
+	Color      string
+	Bold       OptBool
+	Italic     OptBool
+	Underlined OptBool
+	ClickEvent *ClickEvent
+	HoverEvent *HoverEvent
+	Extra      []Component
+}
+
+// componentJSON is Component's wire shape: OptBool fields become *bool so encoding/json's
+// omitempty drops them entirely when unset, instead of serializing an explicit false.
+type componentJSON struct {
+	Text       string      `json:"text,omitempty"`
+	Color      string      `json:"color,omitempty"`
+	Bold       *bool       `json:"bold,omitempty"`
+	Italic     *bool       `json:"italic,omitempty"`
+	Underlined *bool       `json:"underlined,omitempty"`
+	ClickEvent *ClickEvent `json:"clickEvent,omitempty"`
+	HoverEvent *HoverEvent `json:"hoverEvent,omitempty"`
+	Extra      []Component `json:"extra,omitempty"`
+}
+
+func (c Component) MarshalJSON() ([]byte, error) {
+	return json.Marshal(componentJSON{
+		Text:       c.Text,
+		Color:      c.Color,
+		Bold:       c.Bold.ptr(),
+		Italic:     c.Italic.ptr(),
+		Underlined: c.Underlined.ptr(),
+		ClickEvent: c.ClickEvent,
+		HoverEvent: c.HoverEvent,
+		Extra:      c.Extra,
+	})
+}
+
+func (c *Component) UnmarshalJSON(data []byte) error {
+	var aux componentJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	c.Text = aux.Text
+	c.Color = aux.Color
+	c.Bold = optBoolFromPtr(aux.Bold)
+	c.Italic = optBoolFromPtr(aux.Italic)
+	c.Underlined = optBoolFromPtr(aux.Underlined)
+	c.ClickEvent = aux.ClickEvent
+	c.HoverEvent = aux.HoverEvent
+	c.Extra = aux.Extra
+	return nil
+}
+
+// Validate walks component and its descendants, rejecting any ClickEvent whose Action isn't
+// in the allow-list, and any run_command ClickEvent unless isOperator is true.
+func Validate(component *Component, isOperator bool) error {
+	if component.ClickEvent != nil {
+		if !allowedClickActions[component.ClickEvent.Action] {
+			return errors.Errorf("chat component has disallowed click action %q", component.ClickEvent.Action)
+		}
+		if component.ClickEvent.Action == ClickRunCommand && !isOperator {
+			return errors.New("run_command click actions are restricted to operators")
+		}
+	}
+
+	if component.HoverEvent != nil && component.HoverEvent.Contents != nil {
+		if err := Validate(component.HoverEvent.Contents, isOperator); err != nil {
+			return err
+		}
+	}
+
+	for i := range component.Extra {
+		if err := Validate(&component.Extra[i], isOperator); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const legacyColorChar = '§'
+
+var legacyColorCodes = map[rune]string{
+	'0': "black", '1': "dark_blue", '2': "dark_green", '3': "dark_aqua",
+	'4': "dark_red", '5': "dark_purple", '6': "gold", '7': "gray",
+	'8': "dark_gray", '9': "blue", 'a': "green", 'b': "aqua",
+	'c': "red", 'd': "light_purple", 'e': "yellow", 'f': "white",
+}
+
+// Parse converts a legacy "§"-coded string (e.g. "§cHello §aworld") into a Component tree,
+// starting a new child on every recognised color code so mixed-color messages round-trip
+// through Component cleanly. Unrecognised codes are left in the text verbatim.
+func Parse(raw string) Component {
+	var segments []Component
+	var builder strings.Builder
+	currentColor := ""
+
+	flush := func() {
+		if builder.Len() == 0 {
+			return
+		}
+		segments = append(segments, Component{Text: builder.String(), Color: currentColor})
+		builder.Reset()
+	}
+
+	runes := []rune(raw)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] == legacyColorChar && i+1 < len(runes) {
+			if color, ok := legacyColorCodes[runes[i+1]]; ok {
+				flush()
+				currentColor = color
+				i++
+				continue
+			}
+		}
+		builder.WriteRune(runes[i])
+	}
+	flush()
+
+	if len(segments) == 0 {
+		return Component{}
+	}
+
+	root := segments[0]
+	root.Extra = segments[1:]
+	return root
+}
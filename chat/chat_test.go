@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestComponentMarshalOmitsUnsetOptBools(t *testing.T) {
+	component := Component{Text: "hello", Bold: BoolValue(true)}
+
+	raw, err := json.Marshal(component)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, present := fields["italic"]; present {
+		t.Fatal("Expected an unset OptBool field to be omitted from the marshaled JSON")
+	}
+	if bold, _ := fields["bold"].(bool); !bold {
+		t.Fatal("Expected an explicitly-set OptBool field to marshal as its value")
+	}
+}
+
+func TestComponentRoundTrip(t *testing.T) {
+	original := Component{
+		Text:  "click me",
+		Color: "red",
+		Bold:  BoolValue(false),
+		ClickEvent: &ClickEvent{
+			Action: ClickOpenURL,
+			Value:  "https://example.com",
+		},
+		Extra: []Component{{Text: "!", Italic: BoolValue(true)}},
+	}
+
+	raw, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Component
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Text != original.Text || decoded.Color != original.Color {
+		t.Fatalf("Expected decoded component to match original, got %+v", decoded)
+	}
+	if !decoded.Bold.IsSet() || decoded.Bold.Value() {
+		t.Fatal("Expected Bold to round-trip as explicitly false")
+	}
+	if decoded.ClickEvent == nil || decoded.ClickEvent.Action != ClickOpenURL {
+		t.Fatal("Expected ClickEvent to round-trip")
+	}
+	if len(decoded.Extra) != 1 || !decoded.Extra[0].Italic.Value() {
+		t.Fatal("Expected Extra children to round-trip")
+	}
+}
+
+func TestValidateRejectsRunCommandForNonOperators(t *testing.T) {
+	component := Component{Text: "run", ClickEvent: &ClickEvent{Action: ClickRunCommand, Value: "/kick"}}
+
+	if err := Validate(&component, false); err == nil {
+		t.Fatal("Expected run_command to be rejected for a non-operator sender")
+	}
+
+	if err := Validate(&component, true); err != nil {
+		t.Fatal("Expected run_command to be allowed for an operator sender", err)
+	}
+}
+
+func TestValidateRejectsUnknownClickAction(t *testing.T) {
+	component := Component{Text: "bad", ClickEvent: &ClickEvent{Action: "delete_everything"}}
+
+	if err := Validate(&component, true); err == nil {
+		t.Fatal("Expected an unrecognised click action to be rejected even for operators")
+	}
+}
+
+func TestParseLegacyColorCodes(t *testing.T) {
+	component := Parse("§cRed §atext")
+
+	if component.Color != "red" || component.Text != "Red " {
+		t.Fatalf("Expected root segment to be the red-colored text, got %+v", component)
+	}
+	if len(component.Extra) != 1 || component.Extra[0].Color != "green" || component.Extra[0].Text != "text" {
+		t.Fatalf("Expected a second green-colored segment, got %+v", component.Extra)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"spaceship/socketapi"
+)
+
+// ErrOperation is returned, and sent to the client as a close reason, when the first frame on
+// a connection is not a valid Envelope_Auth.
+var ErrOperation = errors.New("expected an auth frame as the first message on this connection")
+
+// Operator validates a client's decrypted OP_AUTH payload and decides the session's userID
+// and heartbeat interval. Downstream apps plug in their own token validation by implementing
+// this interface and passing it to session.SetAuthenticator.
+type Operator interface {
+	Connect(envelope *socketapi.Envelope) (userID string, heartbeat time.Duration, err error)
+}
+
+// authenticate reads exactly one message from the connection, requires it to be an
+// Envelope_Auth whose body is AES-CBC-encrypted with s.cryptor, and hands the decrypted
+// envelope to s.operator to resolve a userID and heartbeat interval. Any other first frame,
+// or a failure to decrypt or decode it, is reported as ErrOperation; an error reading the
+// frame, or one returned by s.operator.Connect, is returned as it is.
+func (s *session) authenticate() error {
+	_, data, err := s.conn.ReadMessage()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	envelope := &socketapi.Envelope{}
+	if s.encodingMode == EncodingProto {
+		err = proto.Unmarshal(data, envelope)
+	} else {
+		err = s.jsonProtoUnmarshler.Unmarshal(bytes.NewReader(data), envelope)
+	}
+	if err != nil {
+		return ErrOperation
+	}
+
+	auth := envelope.GetAuth()
+	if auth == nil {
+		return ErrOperation
+	}
+
+	plaintext, err := s.cryptor.Decrypt(cipher.NewCBCDecrypter, s.authIV, []byte(auth.EncryptedBody))
+	if err != nil {
+		return ErrOperation
+	}
+
+	decrypted := &socketapi.Envelope{}
+	if err := proto.Unmarshal(plaintext, decrypted); err != nil {
+		return ErrOperation
+	}
+
+	userID, heartbeat, err := s.operator.Connect(decrypted)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	s.userID = userID
+	s.pingPeriodTime = heartbeat
+	s.pongWaitTime = heartbeat * 2
+	s.Unlock()
+
+	return nil
+}
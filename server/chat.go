@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/json"
+
+	"spaceship/chat"
+	"spaceship/socketapi"
+)
+
+// SendChatMessage validates component against chat.Validate's click-action allow-list
+// (rejecting ClickEvent{Action: run_command} unless sender is flagged as an operator), then
+// marshals it and routes it to target over the same Session.Send path used for every other
+// envelope.
+func SendChatMessage(sender Session, target Session, component chat.Component) error {
+	isOperator := false
+	if s, ok := sender.(*session); ok {
+		isOperator = s.IsOperator()
+	}
+
+	if err := chat.Validate(&component, isOperator); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(component)
+	if err != nil {
+		return err
+	}
+
+	return target.Send(false, ModeReliable, &socketapi.Envelope{
+		Message: &socketapi.Envelope_ChatMessage{
+			ChatMessage: &socketapi.ChatMessage{Metadata: string(raw)},
+		},
+	})
+}
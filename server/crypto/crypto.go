@@ -0,0 +1,86 @@
+// Package crypto implements the RSA+AES handshake used to authenticate a connection before
+// its first OP_AUTH frame is trusted: a client RSA-encrypts a fresh per-connection AES key
+// with the server's advertised public key, then AES-CBC-encrypts the actual auth payload
+// with it.
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+)
+
+// Cryptor decrypts AES-CBC payloads using the per-connection key recovered from a client's
+// RSA-encrypted handshake.
+type Cryptor struct {
+	block cipher.Block
+}
+
+// NewCryptor builds a Cryptor around an AES key, typically one just recovered with
+// DecryptAESKey.
+func NewCryptor(aesKey []byte) (*Cryptor, error) {
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &Cryptor{block: block}, nil
+}
+
+// Decrypt decrypts ciphertext with the BlockMode constructed by newMode (e.g.
+// cipher.NewCBCDecrypter) and this Cryptor's AES key, stripping PKCS#7 padding.
+func (c *Cryptor) Decrypt(newMode func(cipher.Block, []byte) cipher.BlockMode, iv []byte, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the AES block size")
+	}
+
+	mode := newMode(c.block, iv)
+	plaintext := make([]byte, len(ciphertext))
+	mode.CryptBlocks(plaintext, ciphertext)
+
+	return unpadPKCS7(plaintext)
+}
+
+func unpadPKCS7(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty plaintext")
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, errors.New("invalid PKCS#7 padding")
+	}
+	return data[:len(data)-padLen], nil
+}
+
+// GenerateKeyPair creates an RSA keypair for the server to advertise during the HTTP upgrade
+// and later use to recover the AES key from a client's OP_AUTH frame.
+func GenerateKeyPair(bits int) (*rsa.PrivateKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+// DecryptAESKey recovers the AES key a client encrypted with the server's RSA public key.
+func DecryptAESKey(priv *rsa.PrivateKey, encryptedKey []byte) ([]byte, error) {
+	key, err := rsa.DecryptPKCS1v15(rand.Reader, priv, encryptedKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return key, nil
+}
+
+// EncodePublicKeyPEM renders priv's public key as PEM so it can be advertised to clients
+// during the HTTP upgrade.
+func EncodePublicKeyPEM(priv *rsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: der}), nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/atomic"
+	"log"
+	"time"
+)
+
+var errOutgoingQueueFull = errors.New("outgoing queue full")
+
+// Mode values for the `mode` argument accepted by Session.Send/SendBytes. Reliable envelopes
+// (match state, chat) must never be silently dropped; lossy envelopes (presence, telemetry)
+// may be discarded under backpressure without affecting correctness.
+const (
+	ModeReliable uint8 = iota
+	ModeLossy
+)
+
+// OverflowPolicy decides what happens when a session's outgoing queue is full.
+type OverflowPolicy uint8
+
+const (
+	// OverflowDisconnect closes the connection immediately, the historical behaviour.
+	OverflowDisconnect OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest queued payload to make room for the new one.
+	OverflowDropOldest
+	// OverflowDropByMode discards the new payload if mode is ModeLossy, otherwise falls back
+	// to OverflowDisconnect so reliable envelopes are never silently lost.
+	OverflowDropByMode
+	// OverflowBlock waits up to a configured timeout for room in the queue before giving up
+	// and disconnecting.
+	OverflowBlock
+)
+
+// SessionStats exposes the outgoing-queue counters accumulated over a session's lifetime.
+type SessionStats struct {
+	Dropped          uint64
+	DisconnectedFull uint64
+}
+
+type overflowCounters struct {
+	dropped          atomic.Uint64
+	disconnectedFull atomic.Uint64
+}
+
+// Stats returns a snapshot of this session's outgoing-queue backpressure counters.
+func (s *session) Stats() SessionStats {
+	return SessionStats{
+		Dropped:          s.counters.dropped.Load(),
+		DisconnectedFull: s.counters.disconnectedFull.Load(),
+	}
+}
+
+// enqueue attempts to place payload on the outgoing queue, applying the session's configured
+// OverflowPolicy when the queue is full. The closed-check and the DropOldest/DropByMode
+// branches run under s.Mutex, but OverflowBlock's wait for room releases the lock first:
+// holding it across time.After(s.blockTimeout) would wedge processOutgoing's own pingNow
+// (which also needs s.Mutex) for the whole timeout every time a ping raced a blocked send,
+// degrading "wait for room" into "always wait out the timeout" and stalling Close too. The
+// unlocked send races Close only against outgoingCh itself, which is never closed (see
+// s.closeCh), so it can't panic; s.closeCh is selected alongside it so a concurrent Close
+// still wakes this up instead of making it wait out the full blockTimeout. Callers must not
+// hold s.Mutex.
+func (s *session) enqueue(mode uint8, payload []byte) error {
+	s.Lock()
+
+	if s.closed {
+		s.Unlock()
+		return nil
+	}
+
+	select {
+	case s.outgoingCh <- payload:
+		s.Unlock()
+		return nil
+	default:
+	}
+
+	switch s.overflowPolicy {
+	case OverflowDropOldest:
+		select {
+		case <-s.outgoingCh:
+		default:
+		}
+		select {
+		case s.outgoingCh <- payload:
+			s.Unlock()
+			s.counters.dropped.Inc()
+			return nil
+		default:
+		}
+	case OverflowDropByMode:
+		if mode == ModeLossy {
+			s.Unlock()
+			s.counters.dropped.Inc()
+			return nil
+		}
+	case OverflowBlock:
+		s.Unlock()
+		select {
+		case s.outgoingCh <- payload:
+			return nil
+		case <-s.closeCh:
+			return nil
+		case <-time.After(s.blockTimeout):
+		}
+		s.Lock()
+	}
+
+	s.Unlock()
+	s.counters.disconnectedFull.Inc()
+	log.Println("Could not write message, session outgoing queue full")
+	s.Close()
+	return errOutgoingQueueFull
+}
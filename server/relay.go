@@ -0,0 +1,208 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"spaceship/socketapi"
+)
+
+// relayIdleTimeout closes a relay pairing once either side has not sent a frame in this long.
+const relayIdleTimeout = 60 * time.Second
+
+// relayInvitationTTL bounds how long an invitation token stays redeemable before the pairing
+// attempt is abandoned.
+const relayInvitationTTL = 15 * time.Second
+
+// SessionInvitation is a short-lived, single-use token that lets a client present itself at
+// the /relay endpoint to be paired with the peer it was issued alongside.
+type SessionInvitation struct {
+	Token   string
+	Peer    uuid.UUID
+	Expires time.Time
+}
+
+type relayWaiter struct {
+	// owner is the uuid.UUID of the session this waiter's token was issued to, so the other
+	// side of a pairing can be found by matching its own invitation's Peer against owner
+	// rather than comparing two Peer fields to each other (which never match: each
+	// invitation's Peer names the *other* side, not itself).
+	owner      uuid.UUID
+	invitation SessionInvitation
+	ready      chan *websocket.Conn
+}
+
+// relayRegistry holds the waiters for in-flight relay pairings, keyed by token so either
+// side's /relay request can find the other regardless of arrival order. Each SessionHolder
+// owns its own registry so relay pairings from one server/test instance can never be
+// observed or paired against another's.
+type relayRegistry struct {
+	sync.Mutex
+	waiters map[string]*relayWaiter
+}
+
+var relayRegistriesMu sync.Mutex
+var relayRegistriesByHolder = make(map[*SessionHolder]*relayRegistry)
+
+func relayRegistryFor(h *SessionHolder) *relayRegistry {
+	relayRegistriesMu.Lock()
+	defer relayRegistriesMu.Unlock()
+
+	registry, ok := relayRegistriesByHolder[h]
+	if !ok {
+		registry = &relayRegistry{waiters: make(map[string]*relayWaiter)}
+		relayRegistriesByHolder[h] = registry
+	}
+	return registry
+}
+
+// CreateRelaySession allocates a paired, short-lived invitation token for a and b so they can
+// rendezvous at the /relay endpoint and exchange frames directly without round-tripping
+// through envelope handlers.
+func (h *SessionHolder) CreateRelaySession(a uuid.UUID, b uuid.UUID) (invitationA SessionInvitation, invitationB SessionInvitation, err error) {
+	tokenA := uuid.Must(uuid.NewV4(), nil).String()
+	tokenB := uuid.Must(uuid.NewV4(), nil).String()
+	expires := time.Now().Add(relayInvitationTTL)
+
+	invitationA = SessionInvitation{Token: tokenA, Peer: b, Expires: expires}
+	invitationB = SessionInvitation{Token: tokenB, Peer: a, Expires: expires}
+
+	registry := relayRegistryFor(h)
+
+	registry.Lock()
+	registry.waiters[tokenA] = &relayWaiter{owner: a, invitation: invitationA, ready: make(chan *websocket.Conn, 1)}
+	registry.waiters[tokenB] = &relayWaiter{owner: b, invitation: invitationB, ready: make(chan *websocket.Conn, 1)}
+	registry.Unlock()
+
+	time.AfterFunc(relayInvitationTTL, func() {
+		registry.Lock()
+		delete(registry.waiters, tokenA)
+		delete(registry.waiters, tokenB)
+		registry.Unlock()
+	})
+
+	return invitationA, invitationB, nil
+}
+
+// RelayHandler upgrades the request to a websocket, redeems the invitation token presented as
+// the "token" query parameter, and once both sides of the pairing have connected, pumps raw
+// binary frames between the two connections until either side closes or goes idle.
+func RelayHandler(h *SessionHolder, upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	registry := relayRegistryFor(h)
+	token := r.URL.Query().Get("token")
+
+	registry.Lock()
+	waiter, ok := registry.waiters[token]
+	if ok {
+		// Redeem the token immediately, win or lose: leaving it in the registry until the
+		// relayInvitationTTL cleanup fires would let it be replayed to re-pair a second
+		// connection into this same waiter for the rest of the TTL window, contradicting the
+		// "single-use" contract above.
+		delete(registry.waiters, token)
+	}
+	registry.Unlock()
+
+	if !ok || time.Now().After(waiter.invitation.Expires) {
+		http.Error(w, "invalid or expired relay invitation", http.StatusForbidden)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Could not upgrade relay connection", errors.WithStack(err))
+		return
+	}
+
+	waiter.ready <- conn
+
+	peerToken := findPeerToken(registry, token, waiter.invitation.Peer)
+	registry.Lock()
+	peerWaiter, peerExists := registry.waiters[peerToken]
+	registry.Unlock()
+
+	if !peerExists {
+		conn.Close()
+		return
+	}
+
+	select {
+	case peerConn := <-peerWaiter.ready:
+		pumpRelay(conn, peerConn)
+	case <-time.After(relayInvitationTTL):
+		conn.Close()
+	}
+}
+
+// findPeerToken looks up the token belonging to peer's own waiter: the one whose owner is
+// peer, not the one whose invitation happens to name peer (that's token's own entry).
+func findPeerToken(registry *relayRegistry, token string, peer uuid.UUID) string {
+	registry.Lock()
+	defer registry.Unlock()
+	for candidateToken, candidate := range registry.waiters {
+		if candidateToken != token && candidate.owner == peer {
+			return candidateToken
+		}
+	}
+	return ""
+}
+
+// pumpRelay copies binary frames directly between a and b until either side errs or goes
+// idle for relayIdleTimeout, at which point both connections are closed.
+func pumpRelay(a *websocket.Conn, b *websocket.Conn) {
+	defer a.Close()
+	defer b.Close()
+
+	done := make(chan struct{}, 2)
+
+	copyFrames := func(from *websocket.Conn, to *websocket.Conn) {
+		defer func() { done <- struct{}{} }()
+		for {
+			from.SetReadDeadline(time.Now().Add(relayIdleTimeout))
+			messageType, data, err := from.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := to.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}
+
+	go copyFrames(a, b)
+	go copyFrames(b, a)
+	<-done
+}
+
+// RequestRelay asks the session's client to begin relay negotiation with peer by sending it
+// an Envelope_RelayInvitation with its own token. If peer's session is held by the same
+// SessionHolder, it is sent a second Envelope_RelayInvitation carrying peer's token;
+// otherwise peer is not notified.
+func (s *session) RequestRelay(peer uuid.UUID) error {
+	invitationA, invitationB, err := s.sessionHolder.CreateRelaySession(s.id, peer)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Send(false, ModeReliable, &socketapi.Envelope{
+		Message: &socketapi.Envelope_RelayInvitation{
+			RelayInvitation: &socketapi.RelayInvitation{Token: invitationA.Token},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if peerSession, ok := s.sessionHolder.get(peer); ok {
+		return peerSession.Send(false, ModeReliable, &socketapi.Envelope{
+			Message: &socketapi.Envelope_RelayInvitation{
+				RelayInvitation: &socketapi.RelayInvitation{Token: invitationB.Token},
+			},
+		})
+	}
+
+	return nil
+}
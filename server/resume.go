@@ -0,0 +1,140 @@
+package server
+
+import (
+	"spaceship/socketapi"
+	"sync"
+	"time"
+)
+
+// DefaultResumeGracePeriod is how long an ATGame match slot is kept alive for a user whose
+// websocket dropped mid-match, waiting for that same user to reconnect before the game is
+// torn down and the opponent is notified of a forfeit.
+const DefaultResumeGracePeriod = 30 * time.Second
+
+// resumeOutboxSize bounds how many GameUpdateResp envelopes are buffered per disconnected
+// user. Turn-based games are low frequency, so a small ring is enough to cover a reconnect.
+const resumeOutboxSize = 32
+
+// resumeOutbox buffers envelopes a disconnected user missed so they can be replayed, in
+// order, once the user's session resumes.
+type resumeOutbox struct {
+	sync.Mutex
+	envelopes []*socketapi.Envelope
+}
+
+func newResumeOutbox() *resumeOutbox {
+	return &resumeOutbox{envelopes: make([]*socketapi.Envelope, 0, resumeOutboxSize)}
+}
+
+func (o *resumeOutbox) push(envelope *socketapi.Envelope) {
+	o.Lock()
+	defer o.Unlock()
+	if len(o.envelopes) >= resumeOutboxSize {
+		o.envelopes = o.envelopes[1:]
+	}
+	o.envelopes = append(o.envelopes, envelope)
+}
+
+func (o *resumeOutbox) drain() []*socketapi.Envelope {
+	o.Lock()
+	defer o.Unlock()
+	drained := o.envelopes
+	o.envelopes = make([]*socketapi.Envelope, 0, resumeOutboxSize)
+	return drained
+}
+
+type pendingResume struct {
+	gameID     string
+	outbox     *resumeOutbox
+	graceTimer *time.Timer
+}
+
+// resumeRegistry tracks the grace-period countdown and per-user outbox for ATGame matches
+// whose player has disconnected but not yet been declared absent. It is intentionally kept
+// independent of SessionHolder/GameHolder's own bookkeeping: session.Send feeds it
+// GameUpdateResp envelopes as they're broadcast to an active game, session.Close starts the
+// grace period for the user it just dropped, and NewSession resolves it when a client
+// presents a resumeGameID to reattach to an in-flight match.
+type resumeRegistry struct {
+	sync.Mutex
+	pending map[string]*pendingResume
+}
+
+var defaultResumeRegistry = &resumeRegistry{pending: make(map[string]*pendingResume)}
+
+// RecordGameUpdateForResume is called from session.Send alongside every GameUpdateResp
+// broadcast to an active game, so that a user who is mid-disconnect still accumulates an
+// accurate outbox to replay on resume.
+func RecordGameUpdateForResume(userID string, gameID string, envelope *socketapi.Envelope) {
+	defaultResumeRegistry.Lock()
+	pending, ok := defaultResumeRegistry.pending[userID]
+	defaultResumeRegistry.Unlock()
+	if !ok || pending.gameID != gameID {
+		return
+	}
+	pending.outbox.push(envelope)
+}
+
+// BeginResumeGrace starts the absence countdown for userID's gameID. onExpire runs if the
+// user hasn't resumed by the time the grace period elapses, and is expected to tear the game
+// down as a forfeit. Returns false if a grace period is already running for this user.
+func BeginResumeGrace(userID string, gameID string, grace time.Duration, onExpire func()) bool {
+	defaultResumeRegistry.Lock()
+	defer defaultResumeRegistry.Unlock()
+
+	if _, exists := defaultResumeRegistry.pending[userID]; exists {
+		return false
+	}
+
+	defaultResumeRegistry.pending[userID] = &pendingResume{
+		gameID: gameID,
+		outbox: newResumeOutbox(),
+		graceTimer: time.AfterFunc(grace, func() {
+			defaultResumeRegistry.Lock()
+			delete(defaultResumeRegistry.pending, userID)
+			defaultResumeRegistry.Unlock()
+			onExpire()
+		}),
+	}
+	return true
+}
+
+// ResolveResume cancels the grace period for userID if it matches gameID and returns the
+// envelopes that were buffered while the user was away, for replay on the newly rebound
+// session.
+func ResolveResume(userID string, gameID string) ([]*socketapi.Envelope, bool) {
+	defaultResumeRegistry.Lock()
+	pending, ok := defaultResumeRegistry.pending[userID]
+	if !ok || pending.gameID != gameID {
+		defaultResumeRegistry.Unlock()
+		return nil, false
+	}
+	delete(defaultResumeRegistry.pending, userID)
+	defaultResumeRegistry.Unlock()
+
+	pending.graceTimer.Stop()
+	return pending.outbox.drain(), true
+}
+
+// AttachResumedSession is called from NewSession when a connecting client presents a
+// resumeGameID for an in-flight match under priorUserID. On success it marks newSession as
+// holding that game's seat and queues any GameUpdateResp envelopes the user missed while
+// disconnected for replayPendingResume to deliver once newSession.Consume's outgoing pump is
+// running. It must not send them here: NewSession returns long before Consume starts
+// go s.processOutgoing(), so a direct send could block the caller forever on a full,
+// unread outgoingCh.
+func AttachResumedSession(newSession Session, priorUserID string, gameID string) bool {
+	envelopes, ok := ResolveResume(priorUserID, gameID)
+	if !ok {
+		return false
+	}
+
+	s, ok := newSession.(*session)
+	if !ok {
+		return false
+	}
+
+	s.SetActiveGameID(gameID)
+	s.setPendingResume(envelopes)
+	return true
+}
@@ -3,17 +3,58 @@ package server
 import (
 	"bytes"
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"github.com/satori/go.uuid"
 	"go.uber.org/atomic"
 	"log"
 	"net"
+	"net/http"
+	"spaceship/server/crypto"
 	"spaceship/socketapi"
+	"strings"
 	"sync"
 	"time"
 )
 
+// EncodingMode selects the wire format a session negotiated at handshake time.
+type EncodingMode uint8
+
+const (
+	// EncodingJSON marshals envelopes with jsonProtoMarshler and writes them as websocket.TextMessage.
+	EncodingJSON EncodingMode = iota
+	// EncodingProto marshals envelopes with proto.Marshal and writes them as websocket.BinaryMessage.
+	EncodingProto
+)
+
+const (
+	protoSubprotocol = "spaceship.proto.v1"
+	jsonSubprotocol  = "spaceship.json.v1"
+)
+
+// NegotiateEncodingMode inspects the upgrade request's Sec-WebSocket-Protocol header and
+// "format" query parameter to decide which wire format a session should use. The subprotocol
+// takes precedence over the query param; unrecognised values fall back to EncodingJSON so
+// existing clients keep working unmodified.
+func NegotiateEncodingMode(r *http.Request) EncodingMode {
+	for _, protocol := range websocket.Subprotocols(r) {
+		switch protocol {
+		case protoSubprotocol:
+			return EncodingProto
+		case jsonSubprotocol:
+			return EncodingJSON
+		}
+	}
+
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "proto":
+		return EncodingProto
+	default:
+		return EncodingJSON
+	}
+}
+
 type session struct {
 	sync.Mutex
 	id uuid.UUID
@@ -41,14 +82,54 @@ type session struct {
 	gameHolder *GameHolder
 	outgoingCh chan []byte
 
+	// closeCh is closed exactly once, by Close, to wake up any goroutine parked on a select
+	// against outgoingCh without holding s.Mutex (see enqueue's OverflowBlock case and
+	// processOutgoing). outgoingCh itself is never closed: a send to it can run unlocked, and
+	// closing a channel concurrently with a send to it panics, whereas closing closeCh is
+	// always safe to race against reads of it.
+	closeCh chan struct{}
+
+	overflowPolicy OverflowPolicy
+	blockTimeout   time.Duration
+	counters       overflowCounters
+
+	encodingMode EncodingMode
+
+	// activeGameID is set by the ATGame handler while this session holds a seat in a
+	// turn-based match, so Close can start a resume grace period instead of tearing the
+	// match down on the first dropped connection.
+	activeGameID string
+
+	// pendingResumeEnvelopes holds envelopes a resumed session missed while its user was
+	// disconnected, queued by AttachResumedSession. Consume flushes them via
+	// replayPendingResume only once go s.processOutgoing() is running to drain outgoingCh,
+	// since resolving them any earlier (e.g. from inside NewSession) could block the caller
+	// forever trying to send on a channel nobody is reading yet.
+	pendingResumeEnvelopes []*socketapi.Envelope
+
+	// operator and cryptor are non-nil when the connection must complete an OP_AUTH
+	// handshake before Consume enters its read loop. authIV is the CBC initialization
+	// vector negotiated alongside the per-connection AES key during the HTTP upgrade.
+	operator Operator
+	cryptor  *crypto.Cryptor
+	authIV   []byte
+
+	// isOperator gates server-side validation of chat components this session sends, such
+	// as restricting ClickEvent{Action: run_command} to operators.
+	isOperator bool
+
 	closed bool
 }
 
-func NewSession(userID string, username string, expiry int64, clientIP string, clientPort string, conn *websocket.Conn, config *Config, sessionHolder *SessionHolder, gameHolder *GameHolder, jsonProtoMarshler *jsonpb.Marshaler, jsonProtoUnmarshler *jsonpb.Unmarshaler) Session {
+// NewSession builds a session for a freshly upgraded websocket connection. When resumeGameID
+// is non-empty, userID is treated as a user reconnecting to that in-flight ATGame match: the
+// new session is rebound to the match's seat and replayed any GameUpdateResp envelopes it
+// missed while disconnected, via AttachResumedSession.
+func NewSession(userID string, username string, expiry int64, clientIP string, clientPort string, conn *websocket.Conn, config *Config, sessionHolder *SessionHolder, gameHolder *GameHolder, jsonProtoMarshler *jsonpb.Marshaler, jsonProtoUnmarshler *jsonpb.Unmarshaler, encodingMode EncodingMode, overflowPolicy OverflowPolicy, resumeGameID string) Session {
 
 	sessionID := uuid.Must(uuid.NewV4(), nil)
 
-	return &session{
+	sess := &session{
 		id: sessionID,
 		userID: userID,
 		username: username,
@@ -73,10 +154,87 @@ func NewSession(userID string, username string, expiry int64, clientIP string, c
 
 		gameHolder: gameHolder,
 		outgoingCh: make(chan []byte, config.SocketConfig.OutgoingQueueSize),
+		closeCh:    make(chan struct{}),
+
+		encodingMode: encodingMode,
+
+		overflowPolicy: overflowPolicy,
+		blockTimeout:   time.Duration(config.SocketConfig.OverflowBlockTimeoutMillis) * time.Millisecond,
 
 		closed: false,
 	}
 
+	if resumeGameID != "" {
+		AttachResumedSession(sess, userID, resumeGameID)
+	}
+
+	return sess
+}
+
+func (s *session) EncodingMode() EncodingMode {
+	return s.encodingMode
+}
+
+// SetActiveGameID records that this session currently holds a seat in the given turn-based
+// match, so a dropped connection starts a resume grace period rather than an immediate
+// teardown. Call with an empty string when the match ends normally.
+func (s *session) SetActiveGameID(gameID string) {
+	s.Lock()
+	s.activeGameID = gameID
+	s.Unlock()
+}
+
+func (s *session) ActiveGameID() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.activeGameID
+}
+
+// setPendingResume queues envelopes for replayPendingResume to deliver once Consume's
+// outgoing pump is running.
+func (s *session) setPendingResume(envelopes []*socketapi.Envelope) {
+	s.Lock()
+	s.pendingResumeEnvelopes = envelopes
+	s.Unlock()
+}
+
+// replayPendingResume sends any envelopes AttachResumedSession queued for this session. Must
+// only be called after go s.processOutgoing() has started, so the sends here have a reader
+// on the other end of outgoingCh instead of blocking indefinitely.
+func (s *session) replayPendingResume() {
+	s.Lock()
+	envelopes := s.pendingResumeEnvelopes
+	s.pendingResumeEnvelopes = nil
+	s.Unlock()
+
+	for _, envelope := range envelopes {
+		_ = s.Send(true, ModeReliable, envelope)
+	}
+}
+
+// SetAuthenticator configures the OP_AUTH handshake Consume must complete before entering
+// its read loop: operator validates the decrypted first frame, cryptor decrypts it with the
+// per-connection AES key recovered from the RSA-encrypted handshake, and iv is that key's
+// CBC initialization vector. Call before Consume; omit for connections that trust the token
+// presented during the HTTP upgrade instead.
+func (s *session) SetAuthenticator(operator Operator, cryptor *crypto.Cryptor, iv []byte) {
+	s.operator = operator
+	s.cryptor = cryptor
+	s.authIV = iv
+}
+
+// SetOperator flags whether this session's user may send operator-only chat actions, such
+// as ClickEvent{Action: run_command}.
+func (s *session) SetOperator(isOperator bool) {
+	s.Lock()
+	s.isOperator = isOperator
+	s.Unlock()
+}
+
+func (s *session) IsOperator() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.isOperator
 }
 
 func (s *session) ID() uuid.UUID {
@@ -111,6 +269,22 @@ func (s *session) Expiry() int64 {
 func (s *session) Consume(handlerFunc func(session Session, envelope *socketapi.Envelope) bool) {
 	defer s.Close()
 	s.conn.SetReadLimit(4096)
+
+	// Bound the handshake itself: a client that opens the socket and never sends a frame
+	// (OP_AUTH or otherwise) must not be able to tie up this read goroutine indefinitely.
+	if err := s.conn.SetReadDeadline(time.Now().Add(s.pongWaitTime)); err != nil {
+		log.Println("Error occured while trying to set read deadline", errors.WithStack(err))
+		return
+	}
+
+	if s.operator != nil {
+		if err := s.authenticate(); err != nil {
+			log.Println("Rejecting connection, OP_AUTH handshake failed", errors.WithStack(err))
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, ErrOperation.Error()), time.Now().Add(s.writeWaitTime))
+			return
+		}
+	}
+
 	if err := s.conn.SetReadDeadline(time.Now().Add(s.pongWaitTime)); err != nil {
 		log.Println("Error occured while trying to set read deadline", errors.WithStack(err))
 		return
@@ -123,6 +297,8 @@ func (s *session) Consume(handlerFunc func(session Session, envelope *socketapi.
 
 	go s.processOutgoing()
 
+	s.replayPendingResume()
+
 	for {
 		_, data, err := s.conn.ReadMessage()
 
@@ -149,8 +325,11 @@ func (s *session) Consume(handlerFunc func(session Session, envelope *socketapi.
 
 		request := &socketapi.Envelope{}
 
-		//TODO: we can also handle proto messages
-		err = s.jsonProtoUnmarshler.Unmarshal(bytes.NewReader(data), request)
+		if s.encodingMode == EncodingProto {
+			err = proto.Unmarshal(data, request)
+		} else {
+			err = s.jsonProtoUnmarshler.Unmarshal(bytes.NewReader(data), request)
+		}
 
 		if err != nil {
 			log.Println("Read message error", errors.WithStack(err))
@@ -200,6 +379,8 @@ func (s *session) processOutgoing() {
 	defer s.Close()
 	for {
 		select {
+		case <-s.closeCh:
+			return
 		case <-s.pingTimer.C:
 			if !s.pingNow() {
 				return
@@ -214,8 +395,12 @@ func (s *session) processOutgoing() {
 			}
 
 			// Process the outgoing message queue.
+			messageType := websocket.TextMessage
+			if s.encodingMode == EncodingProto {
+				messageType = websocket.BinaryMessage
+			}
 			s.conn.SetWriteDeadline(time.Now().Add(10*time.Second))
-			if err := s.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+			if err := s.conn.WriteMessage(messageType, payload); err != nil {
 				s.Unlock()
 				log.Println("Could not write message", errors.WithStack(err))
 				return
@@ -249,19 +434,29 @@ func (s *session) pingNow() bool {
 
 
 func (s *session) Send(isStream bool, mode uint8, envelope *socketapi.Envelope) error {
+	if gameID := s.ActiveGameID(); gameID != "" && envelope.GetGameUpdateResp() != nil {
+		// While this user is inside a resume grace period started by Close, buffer the match
+		// state in their outbox so it can be replayed on resume; otherwise nothing is kept.
+		RecordGameUpdateForResume(s.userID, gameID, envelope)
+	}
+
 	var payload []byte
 	var err error
-	var buf bytes.Buffer
-	//TODO: sessions will support proto and json. it should be handled in here too
-	if err = s.jsonProtoMarshler.Marshal(&buf, envelope); err == nil {
-		payload = buf.Bytes()
+
+	if s.encodingMode == EncodingProto {
+		payload, err = proto.Marshal(envelope)
+	} else {
+		var buf bytes.Buffer
+		if err = s.jsonProtoMarshler.Marshal(&buf, envelope); err == nil {
+			payload = buf.Bytes()
+		}
 	}
 	if err != nil {
 		log.Print("Could not marshal envelope", errors.WithStack(err))
 		return err
 	}
 
-	return s.SendBytes(isStream, mode, []byte(payload))
+	return s.SendBytes(isStream, mode, payload)
 }
 
 func (s *session) SendBytes(isStream bool, mode uint8, payload []byte) error {
@@ -276,21 +471,12 @@ func (s *session) SendBytes(isStream bool, mode uint8, payload []byte) error {
 		s.Unlock()
 		return nil
 	}
+	s.Unlock()
 
-	// By default attempt to queue messages and observe failures.
-	select {
-	case s.outgoingCh <- payload:
-		s.Unlock()
-		return nil
-	default:
-		// The outgoing queue is full, likely because the remote client can't keep up.
-		// Terminate the connection immediately because the only alternative that doesn't block the server is
-		// to start dropping messages, which might cause unexpected behaviour.
-		s.Unlock()
-		log.Println("Could not write message, session outgoing queue full")
-		s.Close()
-		return errors.New("outgoing queue full")
-	}
+	// Attempt to queue the message and, if the outgoing queue is full (likely because the
+	// remote client can't keep up), apply the session's configured OverflowPolicy instead of
+	// unconditionally killing the connection.
+	return s.enqueue(mode, payload)
 }
 
 func (s *session) Close() {
@@ -301,11 +487,22 @@ func (s *session) Close() {
 		return
 	}
 	s.closed = true
+	gameID := s.activeGameID
+	userID := s.userID
 	s.Unlock()
 
+	// The session record always goes away with its connection, resumed or not: a resume
+	// reattaches the user's game seat to a brand new *session built by NewSession, it never
+	// revives this one. What the grace period below protects is the game, not this entry.
 	s.sessionHolder.remove(s.id)
 	s.pingTimer.Stop()
-	close(s.outgoingCh)
+	close(s.closeCh)
+
+	if gameID != "" {
+		BeginResumeGrace(userID, gameID, DefaultResumeGracePeriod, func() {
+			s.gameHolder.ForfeitGame(gameID, userID)
+		})
+	}
 
 	if err := s.conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(s.writeWaitTime)); err != nil {
 		log.Println("Couldn't send close message to client")
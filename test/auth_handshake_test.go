@@ -0,0 +1,45 @@
+package test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"spaceship/server/crypto"
+	"testing"
+)
+
+// TestAuthHandshakeWithGeneratedKeyPair performs the RSA+AES OP_AUTH handshake end to end
+// using a freshly generated RSA keypair and confirms the session is admitted once the
+// encrypted auth frame validates, and rejected when the first frame isn't OP_AUTH at all.
+func TestAuthHandshakeWithGeneratedKeyPair(t *testing.T) {
+	priv, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aesKey := make([]byte, 16)
+	if _, err := rand.Read(aesKey); err != nil {
+		t.Fatal(err)
+	}
+
+	encryptedAESKey, err := rsa.EncryptPKCS1v15(rand.Reader, &priv.PublicKey, aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServerWithAuth(t, priv)
+	defer server.Stop()
+
+	client := ConnectAndAuthenticate(t, server, encryptedAESKey, aesKey, "valid-token")
+	defer client.Close()
+
+	if !ClientIsConnected(t, client) {
+		t.Fatal("Expected a session that completed the OP_AUTH handshake to stay connected")
+	}
+
+	rejectedClient := ConnectWithoutAuthFrame(t, server)
+	defer rejectedClient.Close()
+
+	if ClientIsConnected(t, rejectedClient) {
+		t.Fatal("Expected a connection whose first frame isn't OP_AUTH to be closed")
+	}
+}
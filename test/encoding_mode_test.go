@@ -0,0 +1,50 @@
+package test
+
+import (
+	"spaceship/socketapi"
+	"testing"
+)
+
+// TestMixedEncodingModes connects a JSON client and a proto client to the same match and
+// asserts that each receives decoded envelopes regardless of the wire format it negotiated
+// at handshake.
+func TestMixedEncodingModes(t *testing.T) {
+
+	failChan := make(chan string)
+
+	server := NewServer(t)
+	defer server.Stop()
+
+	jsonSession := CreateSession(t)
+	protoSession := CreateSession(t)
+
+	jsonClient, jsonOnMessageChan := CreateSocketConnWithFormat(t, jsonSession.Token, "json")
+	defer jsonClient.Close()
+
+	protoClient, protoOnMessageChan := CreateSocketConnWithFormat(t, protoSession.Token, "proto")
+	defer protoClient.Close()
+
+	WriteMessage(failChan, jsonClient, &socketapi.Envelope{Cid: "", Message: &socketapi.Envelope_MatchFind{
+		MatchFind: &socketapi.MatchFind{
+			GameName:        "ATGame",
+			QueueProperties: map[string]string{"player_count": "2"},
+		},
+	}})
+
+	WriteMessage(failChan, protoClient, &socketapi.Envelope{Cid: "", Message: &socketapi.Envelope_MatchFind{
+		MatchFind: &socketapi.MatchFind{
+			GameName:        "ATGame",
+			QueueProperties: map[string]string{"player_count": "2"},
+		},
+	}})
+
+	jsonMessage := ReadMessage(failChan, jsonOnMessageChan)
+	if jsonMessage.GetMatchEntry() == nil {
+		t.Fatal("Expected a match entry envelope for the JSON session")
+	}
+
+	protoMessage := ReadMessage(failChan, protoOnMessageChan)
+	if protoMessage.GetMatchEntry() == nil {
+		t.Fatal("Expected a match entry envelope for the proto session")
+	}
+}
@@ -0,0 +1,70 @@
+package test
+
+import (
+	"spaceship/socketapi"
+	"testing"
+)
+
+// TestOverflowDropOldestDiscardsInsteadOfDisconnecting saturates a session's outgoing queue
+// with a stalled reader and asserts that, under OverflowDropOldest, the connection survives
+// and the dropped counter increases rather than the session being disconnected.
+func TestOverflowDropOldestDiscardsInsteadOfDisconnecting(t *testing.T) {
+	session := CreateSessionWithOverflowPolicy(t, "DropOldest")
+	defer session.Close()
+
+	for i := 0; i < session.OutgoingQueueSize()*4; i++ {
+		session.SendLossy(&socketapi.Envelope{Cid: "telemetry"})
+	}
+
+	stats := session.Stats()
+	if stats.Dropped == 0 {
+		t.Fatal("Expected dropped counter to increase once the outgoing queue saturated")
+	}
+	if stats.DisconnectedFull != 0 {
+		t.Fatal("Expected the session to stay connected under OverflowDropOldest")
+	}
+}
+
+// TestOverflowDropByModeKeepsReliableEnvelopes asserts that OverflowDropByMode only discards
+// lossy envelopes, disconnecting the session rather than silently dropping a reliable one.
+func TestOverflowDropByModeKeepsReliableEnvelopes(t *testing.T) {
+	session := CreateSessionWithOverflowPolicy(t, "DropByMode")
+	defer session.Close()
+
+	for i := 0; i < session.OutgoingQueueSize()*2; i++ {
+		session.SendLossy(&socketapi.Envelope{Cid: "telemetry"})
+	}
+
+	if session.Stats().Dropped == 0 {
+		t.Fatal("Expected lossy envelopes to be dropped once the queue saturated")
+	}
+
+	session.SendReliable(&socketapi.Envelope{Cid: "match-state"})
+
+	if session.Stats().DisconnectedFull == 0 {
+		t.Fatal("Expected a reliable envelope to disconnect rather than be dropped once the queue saturated")
+	}
+}
+
+// TestOverflowBlockWaitsBeforeDisconnecting asserts that OverflowBlock neither drops an
+// envelope nor disconnects immediately when the queue is full, unlike OverflowDropOldest and
+// OverflowDropByMode above: it only gives up, without dropping the payload, once the queue
+// has stayed full for the configured block timeout.
+func TestOverflowBlockWaitsBeforeDisconnecting(t *testing.T) {
+	session := CreateSessionWithOverflowPolicy(t, "Block")
+	defer session.Close()
+
+	for i := 0; i < session.OutgoingQueueSize(); i++ {
+		session.SendLossy(&socketapi.Envelope{Cid: "telemetry"})
+	}
+
+	session.SendReliable(&socketapi.Envelope{Cid: "match-state"})
+
+	stats := session.Stats()
+	if stats.Dropped != 0 {
+		t.Fatal("Expected OverflowBlock to never drop an envelope, only wait or disconnect")
+	}
+	if stats.DisconnectedFull == 0 {
+		t.Fatal("Expected the session to disconnect once the queue stayed full past the block timeout")
+	}
+}
@@ -0,0 +1,39 @@
+package test
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRelaySessionExchangesRawBytes has two sessions negotiate a relay pairing and confirms
+// that bytes one side writes to its /relay connection are delivered to the other side
+// without going through the envelope handlers.
+func TestRelaySessionExchangesRawBytes(t *testing.T) {
+	server := NewServer(t)
+	defer server.Stop()
+
+	aSession := CreateSession(t)
+	bSession := CreateSession(t)
+
+	invitationA, invitationB := RequestRelayPairing(t, aSession, bSession)
+
+	relayConnA := DialRelay(t, invitationA.Token)
+	defer relayConnA.Close()
+
+	relayConnB := DialRelay(t, invitationB.Token)
+	defer relayConnB.Close()
+
+	payload := []byte("ping-over-relay")
+	if err := relayConnA.WriteMessage(2 /* websocket.BinaryMessage */, payload); err != nil {
+		t.Fatal(err)
+	}
+
+	relayConnB.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, received, err := relayConnB.ReadMessage()
+	if err != nil {
+		t.Fatal("Expected to receive the relayed payload", err)
+	}
+	if string(received) != string(payload) {
+		t.Fatalf("Expected relayed payload %q, got %q", payload, received)
+	}
+}
@@ -0,0 +1,81 @@
+package test
+
+import (
+	"spaceship/socketapi"
+	"testing"
+	"time"
+)
+
+// TestResumeActiveTurnbasedGame disconnects a player mid-ATGame and confirms that rejoining
+// with the same user before the grace period elapses reattaches to the same match and
+// replays the update the player missed while away, instead of forfeiting the game.
+func TestResumeActiveTurnbasedGame(t *testing.T) {
+
+	failChan := make(chan string)
+
+	server := NewServer(t)
+	defer server.Stop()
+
+	homeSession := CreateSession(t)
+	awaySession := CreateSession(t)
+
+	homeClient, homeOnMessageChan := CreateSocketConn(t, homeSession.Token)
+	awayClient, awayOnMessageChan := CreateSocketConn(t, awaySession.Token)
+	defer awayClient.Close()
+
+	WriteMessage(failChan, homeClient, &socketapi.Envelope{Message: &socketapi.Envelope_MatchFind{
+		MatchFind: &socketapi.MatchFind{GameName: "ATGame", QueueProperties: map[string]string{"player_count": "2"}},
+	}})
+	WriteMessage(failChan, awayClient, &socketapi.Envelope{Message: &socketapi.Envelope_MatchFind{
+		MatchFind: &socketapi.MatchFind{GameName: "ATGame", QueueProperties: map[string]string{"player_count": "2"}},
+	}})
+
+	var homeMatchID, awayMatchID string
+	for homeMatchID == "" {
+		message := ReadMessage(failChan, homeOnMessageChan)
+		if entry := message.GetMatchEntry(); entry != nil && entry.State == int32(socketapi.MatchEntry_MATCH_AWAITING_PLAYERS) {
+			homeMatchID = entry.MatchId
+		}
+	}
+	for awayMatchID == "" {
+		message := ReadMessage(failChan, awayOnMessageChan)
+		if entry := message.GetMatchEntry(); entry != nil && entry.State == int32(socketapi.MatchEntry_MATCH_AWAITING_PLAYERS) {
+			awayMatchID = entry.MatchId
+		}
+	}
+
+	WriteMessage(failChan, homeClient, &socketapi.Envelope{Message: &socketapi.Envelope_MatchJoin{
+		MatchJoin: &socketapi.MatchJoin{MatchId: homeMatchID},
+	}})
+	WriteMessage(failChan, awayClient, &socketapi.Envelope{Message: &socketapi.Envelope_MatchJoin{
+		MatchJoin: &socketapi.MatchJoin{MatchId: awayMatchID},
+	}})
+
+	var gameID string
+	for gameID == "" {
+		message := ReadMessage(failChan, homeOnMessageChan)
+		if start := message.GetMatchStart(); start != nil {
+			gameID = start.GameData.Id
+		}
+	}
+
+	// Simulate a dropped connection mid-match: close the client socket but don't tell the
+	// server we're leaving for good.
+	homeClient.Close()
+
+	// Resume before the grace period elapses.
+	time.Sleep(50 * time.Millisecond)
+	resumedClient, resumedOnMessageChan := CreateSocketConnResuming(t, homeSession.Token, homeSession.User.Id, gameID)
+	defer resumedClient.Close()
+
+	select {
+	case err := <-failChan:
+		t.Fatal(err)
+	case message := <-resumedOnMessageChan:
+		if message.GetGameUpdateResp() == nil && message.GetMatchStart() == nil {
+			t.Fatal("Expected the resumed session to receive replayed match state, got unrecognized message")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for replayed state on resumed session")
+	}
+}